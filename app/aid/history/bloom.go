@@ -0,0 +1,319 @@
+package history
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"os"
+	"path"
+	"sync"
+
+	"github.com/spaolacci/murmur3"
+
+	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logger"
+)
+
+// Deduper 是 Success 去重方式的扩展点，map模式为默认实现，
+// 大规模抓取场景下可通过 config.HISTORY.DEDUP_MODE 切换为布隆过滤器实现以降低内存占用
+type Deduper interface {
+	// Add 记录一个已抓取成功的url，返回值表示该url此前是否已存在（过滤器判定，可能存在误判）
+	Add(key string) (existed bool)
+	// Test 判断一个url是否可能已抓取成功，false 一定未抓取，true 仅表示“可能”
+	Test(key string) bool
+	// Len 近似已记录的url数量
+	Len() int
+	// Load 从磁盘恢复快照与WAL
+	Load() error
+	// Flush 落盘快照，并清空WAL
+	Flush() error
+}
+
+// bloomLayer 是一层定长布隆过滤器，fp为其目标误判率
+type bloomLayer struct {
+	bits []uint64
+	m    uint64 // bit数组长度
+	k    uint   // 哈希函数个数
+	fp   float64
+	n    uint // 已插入的元素数（估算）
+}
+
+func newBloomLayer(n uint, fp float64) *bloomLayer {
+	m := optimalM(n, fp)
+	k := optimalK(m, n)
+	return &bloomLayer{
+		bits: make([]uint64, (m+63)/64),
+		m:    m,
+		k:    k,
+		fp:   fp,
+	}
+}
+
+func optimalM(n uint, fp float64) uint64 {
+	if n == 0 {
+		n = 1
+	}
+	m := -1 * float64(n) * math.Log(fp) / (math.Ln2 * math.Ln2)
+	return uint64(math.Ceil(m))
+}
+
+func optimalK(m uint64, n uint) uint {
+	if n == 0 {
+		n = 1
+	}
+	k := float64(m) / float64(n) * math.Ln2
+	if k < 1 {
+		k = 1
+	}
+	return uint(math.Ceil(k))
+}
+
+// indexes 用两个murmur3种子做双重哈希，派生出k个索引，避免k次独立哈希计算
+func (self *bloomLayer) indexes(key string) []uint64 {
+	h1 := murmur3.Sum64WithSeed([]byte(key), 0)
+	h2 := murmur3.Sum64WithSeed([]byte(key), 1)
+	idx := make([]uint64, self.k)
+	for i := uint(0); i < self.k; i++ {
+		idx[i] = (h1 + uint64(i)*h2) % self.m
+	}
+	return idx
+}
+
+func (self *bloomLayer) add(key string) {
+	for _, i := range self.indexes(key) {
+		self.bits[i/64] |= 1 << (i % 64)
+	}
+	self.n++
+}
+
+func (self *bloomLayer) test(key string) bool {
+	for _, i := range self.indexes(key) {
+		if self.bits[i/64]&(1<<(i%64)) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+func (self *bloomLayer) fillRatio() float64 {
+	return float64(self.n) / float64(self.m/uint64(self.k)+1)
+}
+
+// ScalableBloomFilter 是一组随填充率自动扩容的布隆过滤器层，
+// 每新增一层按 p_i = p * 0.9^i 收紧目标误判率，整体误判率收敛于 p（Scalable Bloom Filter设计）
+type ScalableBloomFilter struct {
+	mu       sync.RWMutex
+	layers   []*bloomLayer
+	p        float64 // 初始目标误判率
+	n        uint    // 初始期望容量
+	wal      *os.File
+	dir      string
+	snapshot string
+	walPath  string
+}
+
+// NewScalableBloomFilter 创建一个以p为目标误判率、n为期望容量的可伸缩布隆过滤器
+// 快照与WAL文件落地于 config.HISTORY.DIR 下，文件名前缀与历史记录共用 FILE_NAME_PREFIX
+func NewScalableBloomFilter(p float64, n uint) *ScalableBloomFilter {
+	dir := config.HISTORY.DIR
+	self := &ScalableBloomFilter{
+		layers:   []*bloomLayer{newBloomLayer(n, p)},
+		p:        p,
+		n:        n,
+		dir:      dir,
+		snapshot: path.Join(dir, SUCCESS_FILE+".bloom"),
+		walPath:  path.Join(dir, SUCCESS_FILE+".bloom.wal"),
+	}
+	return self
+}
+
+func (self *ScalableBloomFilter) Add(key string) bool {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	existed := self.test(key)
+
+	cur := self.layers[len(self.layers)-1]
+	if cur.fillRatio() > 0.5 {
+		pi := self.p * math.Pow(0.9, float64(len(self.layers)))
+		cur = newBloomLayer(self.n, pi)
+		self.layers = append(self.layers, cur)
+	}
+	cur.add(key)
+	self.appendWAL(key)
+	return existed
+}
+
+func (self *ScalableBloomFilter) Test(key string) bool {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	return self.test(key)
+}
+
+func (self *ScalableBloomFilter) test(key string) bool {
+	for _, l := range self.layers {
+		if l.test(key) {
+			return true
+		}
+	}
+	return false
+}
+
+func (self *ScalableBloomFilter) Len() int {
+	self.mu.RLock()
+	defer self.mu.RUnlock()
+	var n int
+	for _, l := range self.layers {
+		n += int(l.n)
+	}
+	return n
+}
+
+// appendWAL 以追加方式记录新加入的key，用于快照之间崩溃恢复
+func (self *ScalableBloomFilter) appendWAL(key string) {
+	if self.wal == nil {
+		f, err := os.OpenFile(self.walPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			logger.Error("布隆过滤器WAL写入失败", "err", err)
+			return
+		}
+		self.wal = f
+	}
+	var lenBuf [4]byte
+	binary.BigEndian.PutUint32(lenBuf[:], uint32(len(key)))
+	self.wal.Write(lenBuf[:])
+	self.wal.Write([]byte(key))
+}
+
+// Load 从磁盘恢复快照，并重放快照之后的WAL，崩溃场景下仍可保证不丢失已去重的key
+func (self *ScalableBloomFilter) Load() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	if f, err := os.Open(self.snapshot); err == nil {
+		defer f.Close()
+		if layers, err := decodeLayers(f); err == nil {
+			self.layers = layers
+		}
+	}
+
+	f, err := os.Open(self.walPath)
+	if err != nil {
+		return nil // WAL不存在视为无增量，不是错误
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+	cur := self.layers[len(self.layers)-1]
+	for {
+		var lenBuf [4]byte
+		if _, err := io.ReadFull(r, lenBuf[:]); err != nil {
+			break
+		}
+		buf := make([]byte, binary.BigEndian.Uint32(lenBuf[:]))
+		if _, err := io.ReadFull(r, buf); err != nil {
+			break
+		}
+		if cur.fillRatio() > 0.5 {
+			pi := self.p * math.Pow(0.9, float64(len(self.layers)))
+			cur = newBloomLayer(self.n, pi)
+			self.layers = append(self.layers, cur)
+		}
+		cur.add(string(buf))
+	}
+	return nil
+}
+
+// Flush 将当前所有层写成一份紧凑的二进制快照，并清空WAL（快照已经包含其内容）
+func (self *ScalableBloomFilter) Flush() error {
+	self.mu.Lock()
+	defer self.mu.Unlock()
+
+	f, err := os.OpenFile(self.snapshot, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	if err := encodeLayers(f, self.layers); err != nil {
+		return err
+	}
+
+	if self.wal != nil {
+		self.wal.Close()
+		self.wal = nil
+	}
+	return os.Remove(self.walPath)
+}
+
+func encodeLayers(w io.Writer, layers []*bloomLayer) error {
+	if err := binary.Write(w, binary.BigEndian, uint32(len(layers))); err != nil {
+		return err
+	}
+	for _, l := range layers {
+		if err := binary.Write(w, binary.BigEndian, l.m); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(l.k)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint64(l.n)); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, uint32(len(l.bits))); err != nil {
+			return err
+		}
+		if err := binary.Write(w, binary.BigEndian, l.bits); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func decodeLayers(r io.Reader) ([]*bloomLayer, error) {
+	var count uint32
+	if err := binary.Read(r, binary.BigEndian, &count); err != nil {
+		return nil, err
+	}
+	layers := make([]*bloomLayer, 0, count)
+	for i := uint32(0); i < count; i++ {
+		l := &bloomLayer{}
+		var k, bitsLen uint32
+		var n uint64
+		if err := binary.Read(r, binary.BigEndian, &l.m); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &k); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &n); err != nil {
+			return nil, err
+		}
+		if err := binary.Read(r, binary.BigEndian, &bitsLen); err != nil {
+			return nil, err
+		}
+		l.k = uint(k)
+		l.n = uint(n)
+		l.bits = make([]uint64, bitsLen)
+		if err := binary.Read(r, binary.BigEndian, l.bits); err != nil {
+			return nil, err
+		}
+		layers = append(layers, l)
+	}
+	return layers, nil
+}
+
+// newDeduper 依据 config.HISTORY.DEDUP_MODE 选择去重实现，默认("", "map")为既有的map模式，返回nil
+// 调用方在nil时应继续使用 Success.old/new 两个map，非nil时使用返回的Deduper
+func newDeduper() Deduper {
+	switch config.HISTORY.DEDUP_MODE {
+	case "bloom":
+		bf := NewScalableBloomFilter(config.HISTORY.BLOOM_FP, config.HISTORY.BLOOM_CAPACITY)
+		if err := bf.Load(); err != nil {
+			logger.Error("布隆过滤器加载快照失败", "err", err)
+		}
+		return bf
+	default:
+		return nil
+	}
+}
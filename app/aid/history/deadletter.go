@@ -0,0 +1,91 @@
+package history
+
+import (
+	"encoding/json"
+	"os"
+	"path"
+
+	"github.com/henrylee2cn/pholcus/app/aid/history/retry"
+	"github.com/henrylee2cn/pholcus/app/downloader/request"
+	"github.com/henrylee2cn/pholcus/common/mgo"
+	"github.com/henrylee2cn/pholcus/common/mysql"
+	"github.com/henrylee2cn/pholcus/common/pool"
+	"github.com/henrylee2cn/pholcus/common/redis"
+	"github.com/henrylee2cn/pholcus/config"
+)
+
+var (
+	DEAD_LETTER_FILE      = config.HISTORY.FILE_NAME_PREFIX + "_dead"
+	DEAD_LETTER_FILE_FULL = path.Join(config.HISTORY.DIR, DEAD_LETTER_FILE)
+)
+
+// deadLetterEntry 是落盘的死信记录
+type deadLetterEntry struct {
+	Request        string `json:"request"`
+	Attempts       int    `json:"attempts"`
+	LastErrorClass string `json:"last_error_class"`
+}
+
+// deadLetter 将超过 config.HISTORY.MAX_ATTEMPTS 的失败请求写入死信表/集合/文件，
+// provider与FlushFailure保持一致，不再参与正常的失败重放
+func deadLetter(provider string, req *request.Request, rec *retry.Record) error {
+	serialized, err := req.Serialize()
+	if err != nil {
+		return err
+	}
+	entry := deadLetterEntry{
+		Request:        serialized,
+		Attempts:       rec.Attempts,
+		LastErrorClass: rec.LastErrorClass,
+	}
+
+	switch provider {
+	case "redis":
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		return redis.Call(func(src pool.Src) error {
+			c := src.(*redis.RedisSrc)
+			spiderName := req.GetSpiderName()
+			if err := c.SAdd(DEAD_LETTER_FILE+":spiders", spiderName); err != nil {
+				return err
+			}
+			return c.HSet(DEAD_LETTER_FILE+":"+spiderName, req.GetUrl(), string(b))
+		})
+
+	case "mgo":
+		return mgo.Mgo(nil, "insert", map[string]interface{}{
+			"Database":   MGO_DB,
+			"Collection": DEAD_LETTER_FILE,
+			"Doc":        entry,
+		})
+
+	case "mysql":
+		db, err := mysql.DB()
+		if err != nil {
+			return err
+		}
+		_, err = mysql.New(db).
+			SetTableName("`" + DEAD_LETTER_FILE + "`").
+			Insert(map[string]interface{}{
+				"request":          entry.Request,
+				"attempts":         entry.Attempts,
+				"last_error_class": entry.LastErrorClass,
+			})
+		return err
+
+	default:
+		f, err := os.OpenFile(DEAD_LETTER_FILE_FULL, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		b, err := json.Marshal(entry)
+		if err != nil {
+			return err
+		}
+		_, err = f.Write(append(b, '\n'))
+		return err
+	}
+}
@@ -6,15 +6,17 @@ import (
 	"os"
 	"path"
 	"sync"
+	"time"
 
 	"gopkg.in/mgo.v2/bson"
 
+	"github.com/henrylee2cn/pholcus/app/aid/history/retry"
 	"github.com/henrylee2cn/pholcus/app/downloader/request"
 	"github.com/henrylee2cn/pholcus/common/mgo"
 	"github.com/henrylee2cn/pholcus/common/mysql"
 	"github.com/henrylee2cn/pholcus/common/pool"
 	"github.com/henrylee2cn/pholcus/config"
-	"github.com/henrylee2cn/pholcus/logs"
+	"github.com/henrylee2cn/pholcus/logger"
 )
 
 type (
@@ -50,6 +52,9 @@ type (
 		*Success
 		*Failure
 		provider string
+		dedup    Deduper                             // 非nil时，成功记录的去重改由该实现承担，Success.old/new退化为小容量场景下的默认实现
+		retries  map[string]map[string]*retry.Record // [蜘蛛名][url]重试状态，驱动UpsertFailure/PullFailure的退避节奏
+		backoff  retry.Policy
 		sync.RWMutex
 	}
 )
@@ -73,6 +78,10 @@ func New() Historier {
 		Failure: &Failure{
 			list: make(map[string]map[string]bool),
 		},
+		// config.HISTORY.DEDUP_MODE == "bloom" 时使用可伸缩布隆过滤器去重，否则沿用map去重（默认）
+		dedup:   newDeduper(),
+		retries: make(map[string]map[string]*retry.Record),
+		backoff: newBackoffPolicy(),
 	}
 }
 
@@ -101,6 +110,10 @@ func (self *History) ReadSuccess(provider string, inherit bool) {
 	}
 
 	switch provider {
+	case "redis":
+		redisReadSuccess(self)
+		return
+
 	case "mgo":
 		var docs = map[string]interface{}{}
 		err := mgo.Mgo(&docs, "find", map[string]interface{}{
@@ -108,7 +121,7 @@ func (self *History) ReadSuccess(provider string, inherit bool) {
 			"Collection": SUCCESS_FILE,
 		})
 		if err != nil {
-			logs.Log.Error(" *     Fail  [读取成功记录][mgo]: %v\n", err)
+			logger.Error("读取成功记录失败", "provider", "mgo", "err", err)
 			return
 		}
 		for _, v := range docs["Docs"].([]interface{}) {
@@ -118,7 +131,7 @@ func (self *History) ReadSuccess(provider string, inherit bool) {
 	case "mysql":
 		db, err := mysql.DB()
 		if err != nil {
-			logs.Log.Error(" *     Fail  [读取成功记录][mysql]: %v\n", err)
+			logger.Error("读取成功记录失败", "provider", "mysql", "err", err)
 			return
 		}
 		rows, err := mysql.New(db).
@@ -135,6 +148,10 @@ func (self *History) ReadSuccess(provider string, inherit bool) {
 		}
 
 	default:
+		if self.dedup != nil {
+			// 布隆过滤器模式下，快照与WAL已在New()阶段的newDeduper()中加载完毕
+			break
+		}
 		f, err := os.Open(SUCCESS_FILE_FULL)
 		if err != nil {
 			return
@@ -144,7 +161,11 @@ func (self *History) ReadSuccess(provider string, inherit bool) {
 		b[0] = '{'
 		json.Unmarshal(append(b, '}'), &self.Success.old)
 	}
-	logs.Log.Informational(" *     [读取成功记录]: %v 条\n", len(self.Success.old))
+	if self.dedup != nil {
+		logger.Info("读取成功记录", "provider", provider, "count", self.dedup.Len(), "mode", "bloom")
+		return
+	}
+	logger.Info("读取成功记录", "provider", provider, "count", len(self.Success.old))
 }
 
 // 读取失败记录
@@ -168,11 +189,15 @@ func (self *History) ReadFailure(provider string, inherit bool) {
 		self.Failure.list = make(map[string]map[string]bool)
 		self.Failure.inheritable = true
 	}
+	self.loadRetryState(provider)
 	var fLen int
 	switch provider {
+	case "redis":
+		fLen = redisReadFailure(self)
+
 	case "mgo":
 		if mgo.Error() != nil {
-			logs.Log.Error(" *     Fail  [读取失败记录][mgo]: %v\n", mgo.Error())
+			logger.Error("读取失败记录失败", "provider", "mgo", "err", mgo.Error())
 			return
 		}
 
@@ -199,14 +224,13 @@ func (self *History) ReadFailure(provider string, inherit bool) {
 	case "mysql":
 		db, err := mysql.DB()
 		if err != nil {
-			logs.Log.Error(" *     Fail  [读取失败记录][mysql]: %v\n", err)
+			logger.Error("读取失败记录失败", "provider", "mysql", "err", err)
 			return
 		}
 		rows, err := mysql.New(db).
 			SetTableName("`" + FAILURE_FILE + "`").
 			SelectAll()
 		if err != nil {
-			// logs.Log.Error("读取Mysql数据库中成功记录失败：%v", err)
 			return
 		}
 
@@ -244,7 +268,132 @@ func (self *History) ReadFailure(provider string, inherit bool) {
 		}
 
 	}
-	logs.Log.Informational(" *     [读取失败记录]: %v 条\n", fLen)
+	logger.Info("读取失败记录", "provider", provider, "count", fLen)
+}
+
+// 更新或加入失败记录
+// 在Failure自身的持久化之上叠加重试状态：递增尝试次数、记录首次/最近失败时间与错误分类，
+// 并按self.backoff计算下一次允许被PullFailure取出的时间点；超过 config.HISTORY.MAX_ATTEMPTS 后转入死信，不再进入正常失败队列
+func (self *History) UpsertFailure(req *request.Request) bool {
+	spiderName := req.GetSpiderName()
+	url := req.GetUrl()
+
+	self.RWMutex.Lock()
+	if _, ok := self.retries[spiderName]; !ok {
+		self.retries[spiderName] = make(map[string]*retry.Record)
+	}
+	rec, ok := self.retries[spiderName][url]
+	now := time.Now()
+	var prevWait time.Duration
+	if ok {
+		prevWait = rec.NextAttemptAt.Sub(rec.LastSeen)
+	} else {
+		rec = &retry.Record{FirstSeen: now}
+		self.retries[spiderName][url] = rec
+	}
+	rec.Attempts++
+	rec.LastSeen = now
+	rec.LastErrorClass = classifyError(req)
+	rec.NextAttemptAt = now.Add(self.backoff.Next(rec.Attempts, prevWait))
+	attempts, provider := rec.Attempts, self.provider
+	self.RWMutex.Unlock()
+
+	if maxAttempts := config.HISTORY.MAX_ATTEMPTS; maxAttempts > 0 && attempts > maxAttempts {
+		if err := deadLetter(provider, req, rec); err != nil {
+			logger.Error("写入死信失败", "spider", spiderName, "url", url, "err", err)
+		}
+		logger.Warn("失败请求超过最大重试次数，转入死信", "spider", spiderName, "url", url, "attempts", attempts)
+		return false
+	}
+	return self.Failure.UpsertFailure(req)
+}
+
+// 更新或加入成功记录
+// redis模式下通过redisCheckAndAddSuccess原子检查并写入对应分片，实现跨节点共享去重；
+// 布隆过滤器模式下以Deduper.Add自身的原子先查后加语义去重（而非分开Test再Add，避免两个并发的新url
+// 都判定为不存在而重复写入），命中时存在误判可能，mgo/mysql模式下ReadSuccess已将全量url加载到
+// Success.old，据此做一次精确复核排除误判；其余模式沿用Success自身的实现
+func (self *History) UpsertSuccess(r Record) bool {
+	self.RWMutex.RLock()
+	provider := self.provider
+	self.RWMutex.RUnlock()
+
+	if provider == "redis" {
+		existed, err := redisCheckAndAddSuccess(r.GetUrl())
+		if err != nil {
+			logger.Error("添加成功记录失败", "provider", "redis", "url", r.GetUrl(), "err", err)
+			return false
+		}
+		return !existed
+	}
+
+	if self.dedup != nil {
+		url := r.GetUrl()
+		existed := self.dedup.Add(url)
+		if existed && (provider == "mgo" || provider == "mysql") {
+			self.RWMutex.RLock()
+			_, existed = self.Success.old[url]
+			self.RWMutex.RUnlock()
+		}
+		if existed {
+			return false
+		}
+	}
+
+	return self.Success.UpsertSuccess(r)
+}
+
+// classifyError 从请求携带的下载错误粗略归类，未实现该能力的Request统一归为unknown
+func classifyError(req *request.Request) string {
+	if c, ok := interface{}(req).(interface{ GetErrClass() string }); ok {
+		return c.GetErrClass()
+	}
+	return "unknown"
+}
+
+// 获取指定蜘蛛在上一次运行时失败的请求
+// redis模式下通过MULTI+HGETALL+DEL原子拉取并清空，其余模式沿用Failure自身的实现
+func (self *History) PullFailure(spiderName string) []*request.Request {
+	self.RWMutex.RLock()
+	provider := self.provider
+	self.RWMutex.RUnlock()
+
+	var reqs []*request.Request
+	if provider == "redis" {
+		reqs = redisPullFailure(spiderName)
+	} else {
+		reqs = self.Failure.PullFailure(spiderName)
+	}
+	return self.filterDue(spiderName, reqs)
+}
+
+// filterDue 只保留 next_attempt_at 已到期的请求，未到期的留到下一轮再拉取
+// 整个读取与过滤过程持锁进行，避免UpsertFailure并发修改同一批*retry.Record时读到半更新的状态
+func (self *History) filterDue(spiderName string, reqs []*request.Request) []*request.Request {
+	self.RWMutex.RLock()
+	defer self.RWMutex.RUnlock()
+
+	states := self.retries[spiderName]
+	if len(states) == 0 {
+		return reqs
+	}
+
+	now := time.Now()
+	due := reqs[:0]
+	var later []*request.Request
+	for _, req := range reqs {
+		rec, ok := states[req.GetUrl()]
+		if !ok || !rec.NextAttemptAt.After(now) {
+			due = append(due, req)
+		} else {
+			later = append(later, req)
+		}
+	}
+	// 未到期的请求放回失败队列，随FlushFailure落盘，下一次PullFailure时再判定
+	for _, req := range later {
+		self.Failure.UpsertFailure(req)
+	}
+	return due
 }
 
 // 清空缓存，但不输出
@@ -261,12 +410,25 @@ func (self *History) FlushSuccess(provider string) {
 	self.RWMutex.Lock()
 	self.provider = provider
 	self.RWMutex.Unlock()
+
+	if provider == "redis" {
+		// redis模式下每条成功记录已由redisCheckAndAddSuccess原子写入对应分片，此处无需再批量落盘
+		return
+	}
+
+	if self.dedup != nil && provider != "mgo" && provider != "mysql" {
+		// 布隆过滤器模式下，新增的成功记录已随UpsertSuccess实时写入WAL，这里仅做快照压缩
+		if err := self.dedup.Flush(); err != nil {
+			logger.Error("压缩布隆过滤器快照失败", "err", err)
+		}
+		return
+	}
+
 	sucLen, err := self.Success.flush(provider)
-	logs.Log.Informational(" * ")
 	if err != nil {
-		logs.Log.Error("%v", err)
+		logger.Error("添加成功记录失败", "provider", provider, "err", err)
 	} else {
-		logs.Log.Informational(" *     [添加成功记录]: %v 条\n", sucLen)
+		logger.Info("添加成功记录", "provider", provider, "count", sucLen)
 	}
 }
 
@@ -275,11 +437,28 @@ func (self *History) FlushFailure(provider string) {
 	self.RWMutex.Lock()
 	self.provider = provider
 	self.RWMutex.Unlock()
+
+	if err := self.flushRetryState(provider); err != nil {
+		logger.Error("保存重试状态失败", "provider", provider, "err", err)
+	}
+
+	if provider == "redis" {
+		var failLen int
+		for spName, reqs := range self.Failure.list {
+			if err := redisFlushFailure(spName, reqs); err != nil {
+				logger.Error("添加失败记录失败", "provider", "redis", "spider", spName, "err", err)
+				continue
+			}
+			failLen += len(reqs)
+		}
+		logger.Info("添加失败记录", "provider", "redis", "count", failLen)
+		return
+	}
+
 	failLen, err := self.Failure.flush(provider)
-	logs.Log.Informational(" * ")
 	if err != nil {
-		logs.Log.Error("%v", err)
+		logger.Error("添加失败记录失败", "provider", provider, "err", err)
 	} else {
-		logs.Log.Informational(" *     [添加失败记录]: %v 条\n", failLen)
+		logger.Info("添加失败记录", "provider", provider, "count", failLen)
 	}
 }
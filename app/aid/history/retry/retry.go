@@ -0,0 +1,70 @@
+// Package retry 为失败请求提供可插拔的退避策略，供history包在UpsertFailure/PullFailure中驱动重试节奏
+package retry
+
+import (
+	"math/rand"
+	"time"
+)
+
+// Record 记录一个失败请求的重试状态，随每次UpsertFailure累加
+type Record struct {
+	Attempts       int       // 已尝试次数（含本次失败）
+	FirstSeen      time.Time // 首次记录失败的时间
+	LastSeen       time.Time // 最近一次失败的时间
+	LastErrorClass string    // 最近一次失败的错误分类，便于区分网络超时/403/解析错误等
+	NextAttemptAt  time.Time // 不早于该时间点才允许PullFailure再次取出重放
+}
+
+// Policy 是退避策略的统一扩展点，根据已尝试次数与上一次的退避间隔给出下一次退避间隔
+type Policy interface {
+	// Next 返回第attempts次失败后的退避间隔，prev为上一次Next返回的间隔（首次为0）
+	Next(attempts int, prev time.Duration) time.Duration
+}
+
+// Fixed 每次失败后都等待相同的固定间隔
+type Fixed struct {
+	Interval time.Duration
+}
+
+func (self Fixed) Next(attempts int, prev time.Duration) time.Duration {
+	return self.Interval
+}
+
+// ExponentialJitter 以Base为基数指数增长，并在[0, 当前间隔]内加入随机抖动，避免雪崩式重试
+type ExponentialJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (self ExponentialJitter) Next(attempts int, prev time.Duration) time.Duration {
+	d := self.Base << uint(attempts-1)
+	if self.Max > 0 && d > self.Max {
+		d = self.Max
+	}
+	if d <= 0 {
+		d = self.Base
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
+// DecorrelatedJitter 实现AWS架构博客中的"去相关抖动"退避：
+// sleep = min(Max, random_between(Base, prev*3))，相比纯指数抖动能进一步打散重试的并发度
+type DecorrelatedJitter struct {
+	Base time.Duration
+	Max  time.Duration
+}
+
+func (self DecorrelatedJitter) Next(attempts int, prev time.Duration) time.Duration {
+	if prev <= 0 {
+		prev = self.Base
+	}
+	upper := prev * 3
+	if upper <= self.Base {
+		upper = self.Base + 1
+	}
+	d := self.Base + time.Duration(rand.Int63n(int64(upper-self.Base)))
+	if self.Max > 0 && d > self.Max {
+		d = self.Max
+	}
+	return d
+}
@@ -0,0 +1,195 @@
+package history
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"path"
+
+	"gopkg.in/mgo.v2/bson"
+
+	"github.com/henrylee2cn/pholcus/app/aid/history/retry"
+	"github.com/henrylee2cn/pholcus/common/mgo"
+	"github.com/henrylee2cn/pholcus/common/mysql"
+	"github.com/henrylee2cn/pholcus/common/pool"
+	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logger"
+)
+
+var (
+	RETRY_FILE      = config.HISTORY.FILE_NAME_PREFIX + "_retry"
+	RETRY_FILE_FULL = path.Join(config.HISTORY.DIR, RETRY_FILE)
+)
+
+// newBackoffPolicy 依据 config.HISTORY.BACKOFF 选择退避策略，未配置或不识别时使用固定间隔
+func newBackoffPolicy() retry.Policy {
+	switch config.HISTORY.BACKOFF {
+	case "exponential":
+		return retry.ExponentialJitter{Base: config.HISTORY.BACKOFF_BASE, Max: config.HISTORY.BACKOFF_MAX}
+	case "decorrelated":
+		return retry.DecorrelatedJitter{Base: config.HISTORY.BACKOFF_BASE, Max: config.HISTORY.BACKOFF_MAX}
+	default:
+		return retry.Fixed{Interval: config.HISTORY.BACKOFF_BASE}
+	}
+}
+
+// retryStateDoc 是mgo/mysql中按蜘蛛名存储的一条重试状态快照，States为该蜘蛛所有url重试状态的JSON编码
+type retryStateDoc struct {
+	SpiderName string `bson:"_id" json:"spider_name"`
+	States     string `bson:"states" json:"states"`
+}
+
+// loadRetryState 按provider从对应存储恢复各蜘蛛、各url的重试状态，与ReadFailure保持同一provider，
+// 使mysql/mgo模式下的退避进度也能像失败记录本身一样跨节点共享，而不止停留在发起节点本地
+// 旧版本（本功能引入前）没有对应的表/集合/文件，属于正常情况而非错误，直接保留空状态即可；
+// 这也是"迁移"的全部含义——缺失的重试状态等价于该url尚无重试记录，UpsertFailure会按首次失败重新起算
+func (self *History) loadRetryState(provider string) {
+	var states map[string]map[string]*retry.Record
+	switch provider {
+	case "mgo":
+		states = mgoLoadRetryState()
+	case "mysql":
+		states = mysqlLoadRetryState()
+	default:
+		states = fileLoadRetryState()
+	}
+	if len(states) == 0 {
+		return
+	}
+	self.RWMutex.Lock()
+	self.retries = states
+	self.RWMutex.Unlock()
+}
+
+func fileLoadRetryState() map[string]map[string]*retry.Record {
+	b, err := ioutil.ReadFile(RETRY_FILE_FULL)
+	if err != nil {
+		return nil
+	}
+	states := make(map[string]map[string]*retry.Record)
+	if err := json.Unmarshal(b, &states); err != nil {
+		// 旧格式或损坏的文件：不中断读取流程，仅丢弃重试状态，退回"首次失败"起算
+		logger.Warn("重试状态文件格式不兼容，已忽略", "file", RETRY_FILE_FULL, "err", err)
+		return nil
+	}
+	return states
+}
+
+func mgoLoadRetryState() map[string]map[string]*retry.Record {
+	if mgo.Error() != nil {
+		logger.Error("读取重试状态失败", "provider", "mgo", "err", mgo.Error())
+		return nil
+	}
+	states := make(map[string]map[string]*retry.Record)
+	mgo.Call(func(src pool.Src) error {
+		c := src.(*mgo.MgoSrc).DB(MGO_DB).C(RETRY_FILE)
+		var docs []retryStateDoc
+		if err := c.Find(nil).All(&docs); err != nil {
+			return err
+		}
+		for _, doc := range docs {
+			spStates := make(map[string]*retry.Record)
+			if err := json.Unmarshal([]byte(doc.States), &spStates); err != nil {
+				logger.Warn("重试状态记录格式不兼容，已忽略", "spider", doc.SpiderName, "err", err)
+				continue
+			}
+			states[doc.SpiderName] = spStates
+		}
+		return nil
+	})
+	return states
+}
+
+func mysqlLoadRetryState() map[string]map[string]*retry.Record {
+	db, err := mysql.DB()
+	if err != nil {
+		logger.Error("读取重试状态失败", "provider", "mysql", "err", err)
+		return nil
+	}
+	rows, err := db.Query("SELECT `spider_name`, `states` FROM `" + RETRY_FILE + "`")
+	if err != nil {
+		// 旧版本（本功能引入前）没有该表，属于正常情况而非错误
+		return nil
+	}
+	defer rows.Close()
+
+	states := make(map[string]map[string]*retry.Record)
+	for rows.Next() {
+		var spiderName, raw string
+		if err := rows.Scan(&spiderName, &raw); err != nil {
+			continue
+		}
+		spStates := make(map[string]*retry.Record)
+		if err := json.Unmarshal([]byte(raw), &spStates); err != nil {
+			logger.Warn("重试状态记录格式不兼容，已忽略", "spider", spiderName, "err", err)
+			continue
+		}
+		states[spiderName] = spStates
+	}
+	return states
+}
+
+// flushRetryState 按provider将当前重试状态写回对应存储，与FlushFailure在同一时机调用
+func (self *History) flushRetryState(provider string) error {
+	self.RWMutex.RLock()
+	states := make(map[string]map[string]*retry.Record, len(self.retries))
+	for spiderName, m := range self.retries {
+		states[spiderName] = m
+	}
+	self.RWMutex.RUnlock()
+
+	switch provider {
+	case "mgo":
+		return mgoFlushRetryState(states)
+	case "mysql":
+		return mysqlFlushRetryState(states)
+	default:
+		return fileFlushRetryState(states)
+	}
+}
+
+func fileFlushRetryState(states map[string]map[string]*retry.Record) error {
+	b, err := json.Marshal(states)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(RETRY_FILE_FULL, b, 0644)
+}
+
+func mgoFlushRetryState(states map[string]map[string]*retry.Record) error {
+	return mgo.Call(func(src pool.Src) error {
+		c := src.(*mgo.MgoSrc).DB(MGO_DB).C(RETRY_FILE)
+		for spiderName, spStates := range states {
+			b, err := json.Marshal(spStates)
+			if err != nil {
+				return err
+			}
+			if _, err := c.UpsertId(spiderName, bson.M{"_id": spiderName, "states": string(b)}); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+func mysqlFlushRetryState(states map[string]map[string]*retry.Record) error {
+	db, err := mysql.DB()
+	if err != nil {
+		return err
+	}
+	for spiderName, spStates := range states {
+		b, err := json.Marshal(spStates)
+		if err != nil {
+			return err
+		}
+		if _, err := db.Exec("DELETE FROM `"+RETRY_FILE+"` WHERE `spider_name` = ?", spiderName); err != nil {
+			return err
+		}
+		if _, err := db.Exec(
+			"INSERT INTO `"+RETRY_FILE+"` (`spider_name`, `states`) VALUES (?, ?)",
+			spiderName, string(b),
+		); err != nil {
+			return err
+		}
+	}
+	return nil
+}
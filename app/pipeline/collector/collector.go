@@ -3,24 +3,36 @@ package collector
 
 import (
 	"runtime"
+	"sync"
 	"time"
 
 	"github.com/henrylee2cn/pholcus/app/spider"
 	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logger"
 	"github.com/henrylee2cn/pholcus/runtime/cache"
 )
 
+// batchStat 是某一个Docker索引当前批次累积的字节数与起始时间，配合sink.BatchPolicy使用
+// dockerOne（调用方协程）与goOutput内部的输出协程会并发访问同一个索引，统一经Collector.batchMu保护
+type batchStat struct {
+	bytes int64
+	start time.Time
+}
+
 // 每个爬取任务的数据容器
 type Collector struct {
 	*spider.Spider
 	*DockerQueue
-	DataChan chan DataCell
-	FileChan chan FileCell
-	ctrl     chan bool //长度为零时退出并输出
-	timing   time.Time //上次输出完成的时间点
-	outType  string    //输出方式
-	sum      [3]uint64 //收集的数据总数[文本过去，文本现在，文件],非并发安全
-	outCount [4]uint   //[文本输出开始，文本输出结束，文件输出开始，文件输出结束]
+	DataChan   chan DataCell
+	FileChan   chan FileCell
+	ctrl       chan bool //长度为零时退出并输出
+	timing     time.Time //上次输出完成的时间点
+	outType    string     //输出方式
+	sink       OutputSink //outType注册的输出方式，未注册时为nil并退回内置文本/文件输出
+	batchMu    sync.Mutex
+	batchStats map[int]*batchStat //[Docker索引]当前批次统计，按索引隔离以避免与下一批次并发互相覆盖
+	sum        [3]uint64 //收集的数据总数[文本过去，文本现在，文件],非并发安全
+	outCount   [4]uint   //[文本输出开始，文本输出结束，文件输出开始，文件输出结束]
 }
 
 func NewCollector() *Collector {
@@ -43,6 +55,13 @@ func (self *Collector) Init(sp *spider.Spider) {
 	self.sum = [3]uint64{}
 	self.outCount = [4]uint{}
 	self.timing = cache.StartTime
+	self.batchStats = make(map[int]*batchStat)
+
+	sink, err := newOutputSink(self.outType)
+	if err != nil {
+		logger.Error("创建输出实例失败", "outType", self.outType, "err", err)
+	}
+	self.sink = sink
 }
 
 func (self *Collector) CollectData(dataCell DataCell) {
@@ -93,12 +112,20 @@ func (self *Collector) Manage() {
 
 	// 返回报告
 	self.Report()
+
+	// 释放输出方式占用的连接等资源，例如mongo supervisor协程
+	if self.sink != nil {
+		if err := self.sink.Close(); err != nil {
+			logger.Error("关闭输出实例失败", "sink", self.sink.Name(), "err", err)
+		}
+	}
 }
 
 func (self *Collector) dockerOne(data DataCell) {
 	self.Dockers[self.Curr] = append(self.Dockers[self.Curr], data)
+	self.addBatchBytes(self.Curr, estimateSize(data))
 
-	if len(self.Dockers[self.Curr]) >= cache.Task.DockerCap {
+	if self.shouldFlush() {
 		// curDocker存满后输出
 		self.goOutput(self.Curr)
 		// 更换一个空Docker用于curDocker
@@ -106,14 +133,98 @@ func (self *Collector) dockerOne(data DataCell) {
 	}
 }
 
+// shouldFlush 判断当前Docker是否应当输出
+// 已注册输出方式（self.sink）自带批量策略时以其为准，否则退回 DockerCap 的固定阈值
+func (self *Collector) shouldFlush() bool {
+	n := len(self.Dockers[self.Curr])
+	if self.sink == nil {
+		return n >= cache.Task.DockerCap
+	}
+	policy := self.sink.BatchPolicy()
+	if policy.MaxDocs == 0 && policy.MaxBytes == 0 && policy.MaxLatency == 0 {
+		return n >= cache.Task.DockerCap
+	}
+	if policy.MaxDocs > 0 && n >= policy.MaxDocs {
+		return true
+	}
+	stat := self.batchSnapshot(self.Curr)
+	if policy.MaxBytes > 0 && stat.bytes >= policy.MaxBytes {
+		return true
+	}
+	if policy.MaxLatency > 0 && time.Since(stat.start) >= policy.MaxLatency {
+		return true
+	}
+	return false
+}
+
 func (self *Collector) goOutput(dataIndex int) {
 	self.outCount[0]++
+	docs := self.Dockers[dataIndex]
+	sink := self.sink
 	go func() {
-		self.Output(dataIndex)
+		if sink != nil {
+			if err := sink.BulkWrite(self.Spider.GetName(), self.GetKeyword(), docs); err != nil {
+				logger.Error("输出失败", "sink", sink.Name(), "spider", self.Spider.GetName(), "err", err)
+			}
+		} else {
+			self.Output(dataIndex)
+		}
 		self.outCount[1]++
+		// 仅清空本次输出对应的Docker索引的统计，不影响dockerOne此时已在累积的新批次
+		self.resetBatchStat(dataIndex)
 	}()
 }
 
+// addBatchBytes 为index对应的批次累加已写入字节数，首次访问时惰性初始化起始时间
+func (self *Collector) addBatchBytes(index int, n int64) {
+	self.batchMu.Lock()
+	defer self.batchMu.Unlock()
+	s, ok := self.batchStats[index]
+	if !ok {
+		s = &batchStat{start: time.Now()}
+		self.batchStats[index] = s
+	}
+	s.bytes += n
+}
+
+// batchSnapshot 返回index对应批次统计的一份拷贝，避免调用方持有指针越过锁读取
+func (self *Collector) batchSnapshot(index int) batchStat {
+	self.batchMu.Lock()
+	defer self.batchMu.Unlock()
+	s, ok := self.batchStats[index]
+	if !ok {
+		return batchStat{start: time.Now()}
+	}
+	return *s
+}
+
+// resetBatchStat 清空index对应批次的统计，供goOutput在该批次输出完成后调用
+func (self *Collector) resetBatchStat(index int) {
+	self.batchMu.Lock()
+	defer self.batchMu.Unlock()
+	delete(self.batchStats, index)
+}
+
+// estimateSize 粗略估算一条数据的字节数，用于 BatchPolicy.MaxBytes 的判定
+func estimateSize(data DataCell) int64 {
+	m, ok := data.(map[string]interface{})
+	if !ok {
+		return 0
+	}
+	var n int64
+	for k, v := range m {
+		n += int64(len(k)) + int64(len(fmtSize(v)))
+	}
+	return n
+}
+
+func fmtSize(v interface{}) string {
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return ""
+}
+
 // 获取文本数据总量
 func (self *Collector) dataSum() uint64 {
 	return self.sum[1]
@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"sync"
+	"time"
+)
+
+// BatchPolicy 描述一个输出方式触发批量写入的条件，任一项达到即触发
+// 各字段为零值表示该项不限制，全部为零值时退回 cache.Task.DockerCap 的固定阈值
+type BatchPolicy struct {
+	MaxDocs    int           // 单批最大文档数
+	MaxBytes   int64         // 单批最大字节数（按文档粗略估算）
+	MaxLatency time.Duration // 单批最大等待时长
+}
+
+// OutputSink 是数据落地方式的统一扩展点，内置的文本/文件输出之外，
+// 第三方蜘蛛可实现该接口并通过 RegisterOutput 注册，再经 cache.Task.OutType 选用
+type OutputSink interface {
+	// Name 返回与 cache.Task.OutType 对应的输出方式名称
+	Name() string
+	// BulkWrite 批量写入一组数据，spiderName/keyword 用于分片、建索引等场景
+	BulkWrite(spiderName, keyword string, docs []DataCell) error
+	// BatchPolicy 返回该输出方式偏好的批量触发策略
+	BatchPolicy() BatchPolicy
+	// Close 释放该输出方式占用的连接等资源
+	Close() error
+}
+
+// OutputSinkFactory 创建一个新的 OutputSink 实例，每个Collector在Init时创建一个，其所有输出协程共用该实例
+type OutputSinkFactory func() (OutputSink, error)
+
+var (
+	outputSinkMu        sync.RWMutex
+	outputSinkFactories = make(map[string]OutputSinkFactory)
+)
+
+// RegisterOutput 注册一种新的输出方式，name 与 cache.Task.OutType 对应
+// 重复注册同名 name 时，后者覆盖前者
+func RegisterOutput(name string, factory OutputSinkFactory) {
+	outputSinkMu.Lock()
+	defer outputSinkMu.Unlock()
+	outputSinkFactories[name] = factory
+}
+
+// newOutputSink 依据 outType 创建对应的 OutputSink
+// 未注册该 outType 时返回 (nil, nil)，调用方应回退到内置的文本/文件输出
+func newOutputSink(outType string) (OutputSink, error) {
+	outputSinkMu.RLock()
+	factory, ok := outputSinkFactories[outType]
+	outputSinkMu.RUnlock()
+	if !ok {
+		return nil, nil
+	}
+	return factory()
+}
@@ -0,0 +1,64 @@
+// Elasticsearch输出方式：以bulk index接口批量写入采集数据
+package sink
+
+import (
+	"context"
+	"fmt"
+
+	"gopkg.in/olivere/elastic.v5"
+
+	"github.com/henrylee2cn/pholcus/app/pipeline/collector"
+	"github.com/henrylee2cn/pholcus/config"
+)
+
+func init() {
+	collector.RegisterOutput("es", newESSink)
+}
+
+// esSink 将一批DataCell以bulk index方式写入Elasticsearch
+type esSink struct {
+	client  *elastic.Client
+	index   string
+	typ     string
+	refresh string // 每批写入的刷新策略："false"/"true"/"wait_for"
+	batch   collector.BatchPolicy
+}
+
+func newESSink() (collector.OutputSink, error) {
+	client, err := elastic.NewClient(elastic.SetURL(config.ES.HOSTS...))
+	if err != nil {
+		return nil, fmt.Errorf("[es]连接失败: %v", err)
+	}
+	return &esSink{
+		client:  client,
+		index:   config.ES.INDEX,
+		typ:     config.ES.TYPE,
+		refresh: config.ES.REFRESH_POLICY,
+		batch: collector.BatchPolicy{
+			MaxDocs:    config.ES.BATCH_MAX_DOCS,
+			MaxBytes:   config.ES.BATCH_MAX_BYTES,
+			MaxLatency: config.ES.BATCH_MAX_LATENCY,
+		},
+	}, nil
+}
+
+func (self *esSink) Name() string { return "es" }
+
+func (self *esSink) BatchPolicy() collector.BatchPolicy { return self.batch }
+
+func (self *esSink) BulkWrite(spiderName, keyword string, docs []collector.DataCell) error {
+	if len(docs) == 0 {
+		return nil
+	}
+	bulk := self.client.Bulk().Index(self.index).Type(self.typ).Refresh(self.refresh)
+	for _, doc := range docs {
+		bulk.Add(elastic.NewBulkIndexRequest().Doc(doc))
+	}
+	_, err := bulk.Do(context.Background())
+	return err
+}
+
+func (self *esSink) Close() error {
+	self.client.Stop()
+	return nil
+}
@@ -0,0 +1,87 @@
+// Kafka输出方式：以异步生产者批量写入采集数据，分区键取自蜘蛛名与关键词
+package sink
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+
+	"github.com/Shopify/sarama"
+
+	"github.com/henrylee2cn/pholcus/app/pipeline/collector"
+	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logger"
+)
+
+func init() {
+	collector.RegisterOutput("kafka", newKafkaSink)
+}
+
+// kafkaSink 将DataCell序列化为JSON后投递到Kafka指定topic
+type kafkaSink struct {
+	producer sarama.AsyncProducer
+	topic    string
+	batch    collector.BatchPolicy
+}
+
+func newKafkaSink() (collector.OutputSink, error) {
+	cfg := sarama.NewConfig()
+	cfg.Producer.RequiredAcks = sarama.RequiredAcks(config.KAFKA.REQUIRED_ACKS)
+	cfg.Producer.Return.Successes = false
+	cfg.Producer.Return.Errors = true
+
+	producer, err := sarama.NewAsyncProducer(config.KAFKA.BROKERS, cfg)
+	if err != nil {
+		return nil, fmt.Errorf("[kafka]连接失败: %v", err)
+	}
+
+	self := &kafkaSink{
+		producer: producer,
+		topic:    config.KAFKA.TOPIC,
+		batch: collector.BatchPolicy{
+			MaxDocs:    config.KAFKA.BATCH_MAX_DOCS,
+			MaxBytes:   config.KAFKA.BATCH_MAX_BYTES,
+			MaxLatency: config.KAFKA.BATCH_MAX_LATENCY,
+		},
+	}
+	go self.logErrors()
+	return self, nil
+}
+
+// logErrors 消费异步生产者的错误通道，避免其阻塞或静默丢失
+func (self *kafkaSink) logErrors() {
+	for err := range self.producer.Errors() {
+		logger.Error("输出失败", "sink", "kafka", "err", err)
+	}
+}
+
+func (self *kafkaSink) Name() string { return "kafka" }
+
+func (self *kafkaSink) BatchPolicy() collector.BatchPolicy { return self.batch }
+
+func (self *kafkaSink) BulkWrite(spiderName, keyword string, docs []collector.DataCell) error {
+	key := sarama.StringEncoder(partitionKey(spiderName, keyword))
+	for _, doc := range docs {
+		b, err := json.Marshal(doc)
+		if err != nil {
+			continue
+		}
+		self.producer.Input() <- &sarama.ProducerMessage{
+			Topic: self.topic,
+			Key:   key,
+			Value: sarama.ByteEncoder(b),
+		}
+	}
+	return nil
+}
+
+func (self *kafkaSink) Close() error {
+	return self.producer.Close()
+}
+
+// partitionKey 以"蜘蛛名-关键词"派生分区键，保证同一任务的数据落在同一分区、保序
+func partitionKey(spiderName, keyword string) string {
+	h := fnv.New32a()
+	h.Write([]byte(spiderName + "-" + keyword))
+	return fmt.Sprintf("%x", h.Sum32())
+}
@@ -0,0 +1,127 @@
+// MongoDB输出方式：批量upsert采集数据，每个输出协程克隆一份共享Session
+package sink
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"gopkg.in/mgo.v2"
+
+	"github.com/henrylee2cn/pholcus/app/pipeline/collector"
+	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logger"
+)
+
+func init() {
+	collector.RegisterOutput("mgo", newMongoSink)
+}
+
+// mongoSink 按spiderName对应的集合批量upsert，session损坏时由超时supervisor负责重连
+type mongoSink struct {
+	mu           sync.Mutex
+	session      *mgo.Session
+	db           string
+	pingFailures int
+	maxPingFails int
+	batch        collector.BatchPolicy
+	stop         chan struct{}
+	closeOnce    sync.Once
+}
+
+func newMongoSink() (collector.OutputSink, error) {
+	session, err := mgo.Dial(config.MGO.DIAL_INFO)
+	if err != nil {
+		return nil, fmt.Errorf("[mgo]连接失败: %v", err)
+	}
+	self := &mongoSink{
+		session:      session,
+		db:           config.MGO.DB,
+		maxPingFails: config.MGO.MAX_PING_FAILURES,
+		batch: collector.BatchPolicy{
+			MaxDocs:    config.MGO.BATCH_MAX_DOCS,
+			MaxBytes:   config.MGO.BATCH_MAX_BYTES,
+			MaxLatency: config.MGO.BATCH_MAX_LATENCY,
+		},
+		stop: make(chan struct{}),
+	}
+	go self.superviseConn()
+	return self, nil
+}
+
+func (self *mongoSink) Name() string { return "mgo" }
+
+func (self *mongoSink) BatchPolicy() collector.BatchPolicy { return self.batch }
+
+func (self *mongoSink) BulkWrite(spiderName, keyword string, docs []collector.DataCell) error {
+	self.mu.Lock()
+	sess := self.session.Clone()
+	self.mu.Unlock()
+	defer sess.Close()
+
+	c := sess.DB(self.db).C(spiderName)
+	bulk := c.Bulk()
+	for _, doc := range docs {
+		if sel, ok := upsertSelector(doc); ok {
+			bulk.Upsert(sel, doc)
+		} else {
+			bulk.Insert(doc)
+		}
+	}
+	_, err := bulk.Run()
+	return err
+}
+
+// Close 停止超时supervisor协程并关闭session，可安全多次调用
+func (self *mongoSink) Close() error {
+	self.closeOnce.Do(func() { close(self.stop) })
+	self.mu.Lock()
+	self.session.Close()
+	self.mu.Unlock()
+	return nil
+}
+
+// superviseConn 周期性ping连接，连续失败达到maxPingFails后重新拨号替换session
+// Close被调用时通过stop通道退出，避免该协程随任务结束而泄漏
+func (self *mongoSink) superviseConn() {
+	ticker := time.NewTicker(5 * time.Second)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-self.stop:
+			return
+		case <-ticker.C:
+		}
+		self.mu.Lock()
+		err := self.session.Ping()
+		if err == nil {
+			self.pingFailures = 0
+			self.mu.Unlock()
+			continue
+		}
+		self.pingFailures++
+		if self.pingFailures >= self.maxPingFails {
+			logger.Warn("连续ping失败，尝试重连", "sink", "mgo", "failures", self.pingFailures)
+			if sess, derr := mgo.Dial(config.MGO.DIAL_INFO); derr == nil {
+				self.session.Close()
+				self.session = sess
+				self.pingFailures = 0
+			}
+		}
+		self.mu.Unlock()
+	}
+}
+
+// upsertSelector 以文档自带的_id作为upsert的匹配条件；没有自带_id的文档（采集到的普通数据的常态）
+// 没有可用的匹配条件，ok返回false，调用方应改为Insert，而不是传nil选择器误伤或匹配到任意文档
+func upsertSelector(doc collector.DataCell) (interface{}, bool) {
+	m, isMap := doc.(map[string]interface{})
+	if !isMap {
+		return nil, false
+	}
+	id, hasID := m["_id"]
+	if !hasID {
+		return nil, false
+	}
+	return map[string]interface{}{"_id": id}, true
+}
@@ -0,0 +1,125 @@
+// Package logger 在logs包之上提供级别过滤、滚动文件与结构化字段的能力，
+// 供Collector/History等I/O密集模块统一输出，并可选以JSON格式对接ELK/Loki
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"time"
+
+	"gopkg.in/natefinch/lumberjack.v2"
+
+	"github.com/henrylee2cn/pholcus/config"
+	"github.com/henrylee2cn/pholcus/logs"
+)
+
+// Level 日志级别，数值越大越严重，低于Logger.level的日志会被过滤
+type Level int
+
+const (
+	DEBUG Level = iota
+	INFO
+	WARN
+	ERROR
+)
+
+func (self Level) String() string {
+	switch self {
+	case DEBUG:
+		return "DEBUG"
+	case INFO:
+		return "INFO"
+	case WARN:
+		return "WARN"
+	case ERROR:
+		return "ERROR"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+var levelNames = map[string]Level{"DEBUG": DEBUG, "INFO": INFO, "WARN": WARN, "ERROR": ERROR}
+
+// Logger 封装logs包，增加级别过滤、按大小滚动的文件落盘，以及k/v结构化字段
+// 文件只在超过MAX_SIZE_MB时切分，不按时间切分；MAX_AGE_DAYS仅用于清理超过保留天数的旧备份
+type Logger struct {
+	level   Level
+	json    bool
+	rotator *lumberjack.Logger
+}
+
+// std 是config.LOG配置出的默认实例，包级函数均委托给它
+var std = New()
+
+// New 依据 config.LOG 构建一个Logger，dir/file/level/savefile与既有yaml配置风格保持一致
+func New() *Logger {
+	lvl, ok := levelNames[config.LOG.LEVEL]
+	if !ok {
+		lvl = INFO
+	}
+	self := &Logger{level: lvl, json: config.LOG.JSON}
+	if config.LOG.SAVEFILE {
+		self.rotator = &lumberjack.Logger{
+			Filename:   path.Join(config.LOG.DIR, config.LOG.FILE),
+			MaxSize:    config.LOG.MAX_SIZE_MB,
+			MaxAge:     config.LOG.MAX_AGE_DAYS,
+			MaxBackups: config.LOG.MAX_BACKUPS,
+			Compress:   true,
+		}
+	}
+	return self
+}
+
+func Debug(msg string, kv ...interface{}) { std.Debug(msg, kv...) }
+func Info(msg string, kv ...interface{})  { std.Info(msg, kv...) }
+func Warn(msg string, kv ...interface{})  { std.Warn(msg, kv...) }
+func Error(msg string, kv ...interface{}) { std.Error(msg, kv...) }
+
+func (self *Logger) Debug(msg string, kv ...interface{}) { self.log(DEBUG, msg, kv...) }
+func (self *Logger) Info(msg string, kv ...interface{})  { self.log(INFO, msg, kv...) }
+func (self *Logger) Warn(msg string, kv ...interface{})  { self.log(WARN, msg, kv...) }
+func (self *Logger) Error(msg string, kv ...interface{}) { self.log(ERROR, msg, kv...) }
+
+func (self *Logger) log(lvl Level, msg string, kv ...interface{}) {
+	if lvl < self.level {
+		return
+	}
+	line := self.format(lvl, msg, kv...)
+	if self.rotator != nil {
+		self.rotator.Write([]byte(line + "\n"))
+	}
+	if lvl >= ERROR {
+		logs.Log.Error("%s", line)
+	} else {
+		logs.Log.Informational("%s", line)
+	}
+}
+
+// format 按配置输出为JSON（便于ELK/Loki采集）或人类可读的 "[LEVEL] msg k=v k=v" 格式
+func (self *Logger) format(lvl Level, msg string, kv ...interface{}) string {
+	if self.json {
+		fields := make(map[string]interface{}, len(kv)/2+3)
+		fields["time"] = time.Now().Format(time.RFC3339)
+		fields["level"] = lvl.String()
+		fields["msg"] = msg
+		for i := 0; i+1 < len(kv); i += 2 {
+			if k, ok := kv[i].(string); ok {
+				fields[k] = kv[i+1]
+			}
+		}
+		b, err := json.Marshal(fields)
+		if err != nil {
+			return msg
+		}
+		return string(b)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("[" + lvl.String() + "] " + msg)
+	for i := 0; i+1 < len(kv); i += 2 {
+		fmt.Fprintf(&buf, " %v=%v", kv[i], kv[i+1])
+	}
+	return buf.String()
+}